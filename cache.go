@@ -0,0 +1,94 @@
+package deferredregex
+
+import (
+	"regexp"
+	"sync"
+	"sync/atomic"
+)
+
+// CacheKey identifies a compiled regexp by pattern and the compilation
+// options that affect its semantics. It is a struct rather than a
+// concatenated string so that, say, the pattern "P:foo" can never collide
+// with a POSIX-tagged "foo".
+type CacheKey struct {
+	Pattern      string
+	POSIX        bool
+	LongestMatch bool
+}
+
+// Cache is implemented by anything that can store compiled regexps keyed by
+// pattern (and compilation options). Install one with SetCache to share
+// compiled regexps across every DeferredRegex in the process, e.g. with a
+// bounded or LRU cache instead of the unbounded default.
+type Cache interface {
+	Get(key CacheKey) (*regexp.Regexp, bool)
+	Put(key CacheKey, re *regexp.Regexp)
+}
+
+// mapCache is the default Cache, backed by a sync.Map so it needs no locking
+// of its own. It never evicts.
+type mapCache struct {
+	m sync.Map
+}
+
+func (c *mapCache) Get(key CacheKey) (*regexp.Regexp, bool) {
+	v, ok := c.m.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*regexp.Regexp), true
+}
+
+func (c *mapCache) Put(key CacheKey, re *regexp.Regexp) {
+	c.m.Store(key, re)
+}
+
+var (
+	cacheMu sync.RWMutex
+	cache   Cache = &mapCache{}
+
+	cacheHits, cacheMisses int64
+	cacheUnique            sync.Map
+)
+
+// SetCache installs c as the process-wide cache consulted when compiling a
+// pattern, so that identical patterns compiled by independent DeferredRegex
+// values across the binary share a single *regexp.Regexp. Passing nil
+// disables caching entirely. Call it once, early in main, before any
+// DeferredRegex is used.
+func SetCache(c Cache) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cache = c
+}
+
+// CacheStats reports how effectively the process-wide cache is amortising
+// compile cost.
+type CacheStats struct {
+	Hits, Misses int64
+	// Unique is the number of distinct cache keys (pattern plus POSIX and
+	// LongestMatch options) compiled and stored in a cache so far. Compiles
+	// made while caching is disabled are not counted.
+	Unique int
+}
+
+// Stats returns the current CacheStats for the process-wide cache.
+func Stats() CacheStats {
+	unique := 0
+	cacheUnique.Range(func(_, _ interface{}) bool {
+		unique++
+		return true
+	})
+	return CacheStats{
+		Hits:   atomic.LoadInt64(&cacheHits),
+		Misses: atomic.LoadInt64(&cacheMisses),
+		Unique: unique,
+	}
+}
+
+// cacheKey incorporates POSIX and LongestMatch into the key so a POSIX or
+// leftmost-longest DeferredRegex never shares a compiled regexp with a plain
+// one for the same pattern.
+func (dr *DeferredRegex) cacheKey() CacheKey {
+	return CacheKey{Pattern: dr.Re, POSIX: dr.POSIX, LongestMatch: dr.LongestMatch}
+}
@@ -0,0 +1,97 @@
+package deferredregex
+
+import "testing"
+
+func TestCacheSharesCompiledRegexp(t *testing.T) {
+	SetCache(&mapCache{})
+
+	a := DeferredRegex{Re: `[0-9]+`}
+	b := DeferredRegex{Re: `[0-9]+`}
+	a.init()
+	b.init()
+	if a.re != b.re {
+		t.Errorf("Expected identical patterns to share a compiled regexp")
+	}
+}
+
+func TestCacheKeySeparatesPOSIXAndLongestMatch(t *testing.T) {
+	SetCache(&mapCache{})
+
+	plain := DeferredRegex{Re: `a|ab`}
+	posix := DeferredRegex{Re: `a|ab`, POSIX: true}
+	plain.init()
+	posix.init()
+	if plain.re == posix.re {
+		t.Errorf("Expected POSIX and non-POSIX regexes not to share a compiled regexp")
+	}
+
+	longest := DeferredRegex{Re: `a|ab`, LongestMatch: true}
+	longest.init()
+	if plain.re == longest.re {
+		t.Errorf("Expected LongestMatch and leftmost-first regexes not to share a compiled regexp")
+	}
+}
+
+func TestCacheKeyDoesNotCollideWithTaggedPrefix(t *testing.T) {
+	SetCache(&mapCache{})
+
+	plain := DeferredRegex{Re: `P:foo`}
+	posix := DeferredRegex{Re: `foo`, POSIX: true}
+	plain.init()
+	posix.init()
+	if plain.re == posix.re {
+		t.Errorf("Expected a plain pattern not to collide with an unrelated POSIX-tagged pattern")
+	}
+}
+
+func TestLongestDoesNotMutateSharedCacheEntry(t *testing.T) {
+	SetCache(&mapCache{})
+
+	a := DeferredRegex{Re: `a|ab`}
+	b := DeferredRegex{Re: `a|ab`}
+	a.init()
+	b.init()
+	if a.re != b.re {
+		t.Fatalf("Expected instances to share a cached regexp before Longest is called")
+	}
+
+	a.Longest()
+	if got := b.FindString("ab"); got != "a" {
+		t.Errorf("Expected an unrelated instance to keep leftmost-first matching, got %q", got)
+	}
+	if got := a.FindString("ab"); got != "ab" {
+		t.Errorf("Expected Longest to take effect on the instance it was called on, got %q", got)
+	}
+}
+
+func TestSetCacheNilDisablesCaching(t *testing.T) {
+	SetCache(nil)
+	defer SetCache(&mapCache{})
+
+	a := DeferredRegex{Re: `[0-9]+`}
+	b := DeferredRegex{Re: `[0-9]+`}
+	a.init()
+	b.init()
+	if a.re == b.re {
+		t.Errorf("Expected caching to be disabled when SetCache(nil) is used")
+	}
+}
+
+func BenchmarkCompileWithoutCache(b *testing.B) {
+	SetCache(nil)
+	defer SetCache(&mapCache{})
+
+	for i := 0; i < b.N; i++ {
+		dr := DeferredRegex{Re: `([0-9]+)\.([0-9]+)\.([0-9]+)`}
+		dr.init()
+	}
+}
+
+func BenchmarkCompileWithCache(b *testing.B) {
+	SetCache(&mapCache{})
+
+	for i := 0; i < b.N; i++ {
+		dr := DeferredRegex{Re: `([0-9]+)\.([0-9]+)\.([0-9]+)`}
+		dr.init()
+	}
+}
@@ -4,231 +4,468 @@
 package deferredregex
 
 import (
+	"encoding/json"
 	"io"
 	"regexp"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
+// posixPrefix, when present at the start of a pattern given to UnmarshalText or
+// UnmarshalFlag, selects POSIX (leftmost-longest) mode, mirroring how regexp's
+// own syntax uses (?i) and friends to toggle flags inline.
+const posixPrefix = "(?posix)"
+
 // A DeferredRegex is like a normal regexp but defers its initialisation until first use.
 //
-// Note that it uses MustCompile internally in order to mimic the regexp interface,
-// so you only want to use it for static regexes that you know are valid (which is
-// typically the only use case you would want this for anyway).
+// Unlike regexp.MustCompile, a compile failure does not panic; it is recorded and
+// returned by Err. While compilation has failed, all other methods behave as though
+// the regex matched nothing, whether or not Err has been called: Find* methods return nil (or the empty string), Match*
+// methods return false, and Replace*/Split methods pass their input through unchanged.
+// This lets callers that accept patterns from config files or command lines
+// distinguish a bad user-supplied pattern from a genuine programmer error.
 //
 // It is safe for concurrent use, except for configuration methods such as Longest.
+// That caveat is per-instance only: the compiled regexp may be shared with other
+// DeferredRegex values of the same pattern and options via the process-wide cache
+// (see SetCache), so Longest takes a private copy before mutating it rather than
+// risk flipping matching behaviour for unrelated instances.
 // It should not be copied.
 type DeferredRegex struct {
-	Re   string
+	Re string
+	// StrictUnmarshal, if true, causes UnmarshalText and UnmarshalFlag to
+	// eagerly validate Re via regexp.Compile (or regexp.CompilePOSIX, per POSIX
+	// below) and return any error immediately. The validated regexp is
+	// discarded; Re is still compiled on first use.
+	StrictUnmarshal bool
+	// POSIX selects leftmost-longest matching semantics via regexp.CompilePOSIX
+	// instead of the default leftmost-first regexp.Compile. It must be set
+	// before the DeferredRegex is first used; UnmarshalText and UnmarshalFlag
+	// also set it when the pattern is prefixed with "(?posix)".
+	POSIX bool
+	// LongestMatch, if true, calls Longest on the compiled regexp as part of
+	// init, so leftmost-longest matching can be selected up front without
+	// forcing compilation the way calling Longest directly would.
+	LongestMatch bool
+
 	once sync.Once
 	re   *regexp.Regexp
+	err  error
 }
 
 func (dr *DeferredRegex) init() {
 	dr.once.Do(func() {
-		dr.re = regexp.MustCompile(dr.Re)
+		cacheMu.RLock()
+		c := cache
+		cacheMu.RUnlock()
+
+		key := dr.cacheKey()
+		if c != nil {
+			if re, ok := c.Get(key); ok {
+				atomic.AddInt64(&cacheHits, 1)
+				dr.re = re
+				return
+			}
+		}
+		atomic.AddInt64(&cacheMisses, 1)
+
+		if dr.POSIX {
+			dr.re, dr.err = regexp.CompilePOSIX(dr.Re)
+		} else {
+			dr.re, dr.err = regexp.Compile(dr.Re)
+		}
+		if dr.err != nil {
+			return
+		}
+		if dr.LongestMatch {
+			dr.re.Longest()
+		}
+		if c != nil {
+			c.Put(key, dr.re)
+			cacheUnique.Store(key, struct{}{})
+		}
 	})
 }
 
+// compile validates Re (honouring POSIX) without storing the result, for use
+// by UnmarshalText and UnmarshalFlag when StrictUnmarshal is set.
+func (dr *DeferredRegex) compile() error {
+	var err error
+	if dr.POSIX {
+		_, err = regexp.CompilePOSIX(dr.Re)
+	} else {
+		_, err = regexp.Compile(dr.Re)
+	}
+	return err
+}
+
+// Err returns the error, if any, encountered compiling Re. Like the matching
+// methods it forces compilation if it hasn't happened yet.
+func (dr *DeferredRegex) Err() error {
+	dr.init()
+	return dr.err
+}
+
 // UnmarshalText implements the encoding.TextUnmarshaler interface
-// Note that it still defers the parse at this point.
+// Note that it still defers the parse at this point, unless StrictUnmarshal is set.
+// A pattern prefixed with "(?posix)" selects POSIX leftmost-longest matching.
 func (dr *DeferredRegex) UnmarshalText(text []byte) error {
-	dr.Re = string(text)
-	return nil
+	return dr.unmarshal(string(text))
 }
 
 // MarshalText implements the encoding.TextMarshaler interface
 func (dr *DeferredRegex) MarshalText() ([]byte, error) {
-	return []byte(dr.Re), nil
+	return []byte(dr.marshal()), nil
 }
 
 // UnmarshalFlag implements the Unmarshaler interface from go-flags
-// Note that it still defers the parse at this point.
+// Note that it still defers the parse at this point, unless StrictUnmarshal is set.
+// A pattern prefixed with "(?posix)" selects POSIX leftmost-longest matching.
 func (dr *DeferredRegex) UnmarshalFlag(in string) error {
-	dr.Re = in
-	return nil
+	return dr.unmarshal(in)
 }
 
 // MarshalFlag implements the Marshaler interface from go-flags
 func (dr *DeferredRegex) MarshalFlag() (string, error) {
-	return dr.Re, nil
+	return dr.marshal(), nil
+}
+
+func (dr *DeferredRegex) unmarshal(s string) error {
+	if strings.HasPrefix(s, posixPrefix) {
+		dr.POSIX = true
+		s = strings.TrimPrefix(s, posixPrefix)
+	}
+	dr.Re = s
+	if dr.StrictUnmarshal {
+		return dr.compile()
+	}
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface, serialising the
+// DeferredRegex as a JSON string rather than as a struct, whose unexported
+// fields would otherwise leak through encoders that don't honour
+// TextMarshaler by default.
+func (dr *DeferredRegex) MarshalJSON() ([]byte, error) {
+	return json.Marshal(dr.marshal())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+// Note that it still defers the parse at this point, unless StrictUnmarshal is set.
+func (dr *DeferredRegex) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return dr.unmarshal(s)
+}
+
+// Pattern returns the source pattern as given to Re, without triggering
+// compilation the way String does. Useful for logging, diffing configs, or
+// describing a pattern in an error message before it has ever been exercised.
+func (dr *DeferredRegex) Pattern() string {
+	return dr.Re
+}
+
+func (dr *DeferredRegex) marshal() string {
+	if dr.POSIX {
+		return posixPrefix + dr.Re
+	}
+	return dr.Re
 }
 
 func (dr *DeferredRegex) Expand(dst []byte, template []byte, src []byte, match []int) []byte {
 	dr.init()
+	if dr.err != nil {
+		return dst
+	}
 	return dr.re.Expand(dst, template, src, match)
 }
 
 func (dr *DeferredRegex) ExpandString(dst []byte, template string, src string, match []int) []byte {
 	dr.init()
+	if dr.err != nil {
+		return dst
+	}
 	return dr.re.ExpandString(dst, template, src, match)
 }
 
 func (dr *DeferredRegex) Find(b []byte) []byte {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.Find(b)
 }
 
 func (dr *DeferredRegex) FindAll(b []byte, n int) [][]byte {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindAll(b, n)
 }
 
 func (dr *DeferredRegex) FindAllIndex(b []byte, n int) [][]int {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindAllIndex(b, n)
 }
 
 func (dr *DeferredRegex) FindAllString(s string, n int) []string {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindAllString(s, n)
 }
 
 func (dr *DeferredRegex) FindAllStringIndex(s string, n int) [][]int {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindAllStringIndex(s, n)
 }
 
 func (dr *DeferredRegex) FindAllStringSubmatch(s string, n int) [][]string {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindAllStringSubmatch(s, n)
 }
 
 func (dr *DeferredRegex) FindAllStringSubmatchIndex(s string, n int) [][]int {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindAllStringSubmatchIndex(s, n)
 }
 
 func (dr *DeferredRegex) FindAllSubmatch(b []byte, n int) [][][]byte {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindAllSubmatch(b, n)
 }
 
 func (dr *DeferredRegex) FindAllSubmatchIndex(b []byte, n int) [][]int {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindAllSubmatchIndex(b, n)
 }
 
 func (dr *DeferredRegex) FindIndex(b []byte) (loc []int) {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindIndex(b)
 }
 
 func (dr *DeferredRegex) FindReaderIndex(r io.RuneReader) (loc []int) {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindReaderIndex(r)
 }
 
 func (dr *DeferredRegex) FindReaderSubmatchIndex(r io.RuneReader) []int {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindReaderSubmatchIndex(r)
 }
 
 func (dr *DeferredRegex) FindString(s string) string {
 	dr.init()
+	if dr.err != nil {
+		return ""
+	}
 	return dr.re.FindString(s)
 }
 
 func (dr *DeferredRegex) FindStringIndex(s string) (loc []int) {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindStringIndex(s)
 }
 
 func (dr *DeferredRegex) FindStringSubmatch(s string) []string {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindStringSubmatch(s)
 }
 
 func (dr *DeferredRegex) FindStringSubmatchIndex(s string) []int {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindStringSubmatchIndex(s)
 }
 
 func (dr *DeferredRegex) FindSubmatch(b []byte) [][]byte {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindSubmatch(b)
 }
 
 func (dr *DeferredRegex) FindSubmatchIndex(b []byte) []int {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.FindSubmatchIndex(b)
 }
 
 func (dr *DeferredRegex) LiteralPrefix() (prefix string, complete bool) {
 	dr.init()
+	if dr.err != nil {
+		return "", false
+	}
 	return dr.re.LiteralPrefix()
 }
 
+// Longest switches dr to leftmost-longest matching, like regexp.Regexp.Longest.
+// Because the compiled regexp may be shared with other DeferredRegex values via
+// the process-wide cache, it first takes a private copy so this never affects
+// matching behaviour elsewhere in the process.
 func (dr *DeferredRegex) Longest() {
 	dr.init()
+	if dr.err != nil {
+		return
+	}
+	dr.re = dr.re.Copy()
 	dr.re.Longest()
 }
 
 func (dr *DeferredRegex) Match(b []byte) bool {
 	dr.init()
+	if dr.err != nil {
+		return false
+	}
 	return dr.re.Match(b)
 }
 
 func (dr *DeferredRegex) MatchReader(r io.RuneReader) bool {
 	dr.init()
+	if dr.err != nil {
+		return false
+	}
 	return dr.re.MatchReader(r)
 }
 
 func (dr *DeferredRegex) MatchString(s string) bool {
 	dr.init()
+	if dr.err != nil {
+		return false
+	}
 	return dr.re.MatchString(s)
 }
 
 func (dr *DeferredRegex) NumSubexp() int {
 	dr.init()
+	if dr.err != nil {
+		return 0
+	}
 	return dr.re.NumSubexp()
 }
 
 func (dr *DeferredRegex) ReplaceAll(src, repl []byte) []byte {
 	dr.init()
+	if dr.err != nil {
+		return src
+	}
 	return dr.re.ReplaceAll(src, repl)
 }
 
 func (dr *DeferredRegex) ReplaceAllFunc(src []byte, repl func([]byte) []byte) []byte {
 	dr.init()
+	if dr.err != nil {
+		return src
+	}
 	return dr.re.ReplaceAllFunc(src, repl)
 }
 
 func (dr *DeferredRegex) ReplaceAllLiteral(src, repl []byte) []byte {
 	dr.init()
+	if dr.err != nil {
+		return src
+	}
 	return dr.re.ReplaceAllLiteral(src, repl)
 }
 
 func (dr *DeferredRegex) ReplaceAllLiteralString(src, repl string) string {
 	dr.init()
+	if dr.err != nil {
+		return src
+	}
 	return dr.re.ReplaceAllLiteralString(src, repl)
 }
 
 func (dr *DeferredRegex) ReplaceAllString(src, repl string) string {
 	dr.init()
+	if dr.err != nil {
+		return src
+	}
 	return dr.re.ReplaceAllString(src, repl)
 }
 
 func (dr *DeferredRegex) ReplaceAllStringFunc(src string, repl func(string) string) string {
 	dr.init()
+	if dr.err != nil {
+		return src
+	}
 	return dr.re.ReplaceAllStringFunc(src, repl)
 }
 
 func (dr *DeferredRegex) Split(s string, n int) []string {
 	dr.init()
+	if dr.err != nil {
+		return []string{s}
+	}
 	return dr.re.Split(s, n)
 }
 
+// String implements the fmt.Stringer interface. Note that it still forces
+// compilation of Re; use Pattern to access the source pattern without doing so.
 func (dr *DeferredRegex) String() string {
 	dr.init()
+	if dr.err != nil {
+		return dr.Re
+	}
 	return dr.re.String()
 }
 
 func (dr *DeferredRegex) SubexpIndex(name string) int {
 	dr.init()
+	if dr.err != nil {
+		return -1
+	}
 	return dr.re.SubexpIndex(name)
 }
 
 func (dr *DeferredRegex) SubexpNames() []string {
 	dr.init()
+	if dr.err != nil {
+		return nil
+	}
 	return dr.re.SubexpNames()
 }
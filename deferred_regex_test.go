@@ -2,6 +2,7 @@ package deferredregex
 
 import (
 	"encoding"
+	"encoding/json"
 	"testing"
 )
 
@@ -29,6 +30,99 @@ func TestTextUnmarshaler(t *testing.T) {
 	}
 }
 
+func TestErrOnBadPattern(t *testing.T) {
+	dr := DeferredRegex{Re: `[`}
+	if err := dr.Err(); err == nil {
+		t.Errorf("Expected an error for an invalid pattern")
+	}
+	if dr.MatchString("anything") {
+		t.Errorf("Expected no match for a regex with a compile error")
+	}
+	if dr.ReplaceAllString("unchanged", "x") != "unchanged" {
+		t.Errorf("Expected ReplaceAllString to pass through unchanged")
+	}
+}
+
+func TestStrictUnmarshalRejectsBadPattern(t *testing.T) {
+	dr := DeferredRegex{StrictUnmarshal: true}
+	if err := dr.UnmarshalText([]byte(`[`)); err == nil {
+		t.Errorf("Expected UnmarshalText to report the compile error immediately")
+	}
+	if err := dr.UnmarshalFlag(`[`); err == nil {
+		t.Errorf("Expected UnmarshalFlag to report the compile error immediately")
+	}
+}
+
+func TestPOSIXLeftmostLongest(t *testing.T) {
+	dr := DeferredRegex{Re: `a|ab`, POSIX: true}
+	if got := dr.FindString("ab"); got != "ab" {
+		t.Errorf("Expected POSIX leftmost-longest match %q, got %q", "ab", got)
+	}
+}
+
+func TestLongestMatchField(t *testing.T) {
+	dr := DeferredRegex{Re: `a|ab`, LongestMatch: true}
+	if got := dr.FindString("ab"); got != "ab" {
+		t.Errorf("Expected leftmost-longest match %q, got %q", "ab", got)
+	}
+}
+
+func TestUnmarshalPOSIXPrefix(t *testing.T) {
+	dr := DeferredRegex{}
+	if err := dr.UnmarshalText([]byte(`(?posix)a|ab`)); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+	if !dr.POSIX {
+		t.Errorf("Expected (?posix) prefix to set POSIX mode")
+	}
+	if dr.Re != `a|ab` {
+		t.Errorf("Expected prefix to be stripped from Re, got %q", dr.Re)
+	}
+	if got := dr.FindString("ab"); got != "ab" {
+		t.Errorf("Expected POSIX leftmost-longest match %q, got %q", "ab", got)
+	}
+	text, err := dr.MarshalText()
+	if err != nil {
+		t.Errorf("Unexpected error %s", err)
+	} else if string(text) != `(?posix)a|ab` {
+		t.Errorf("Expected round-tripped text to retain (?posix) prefix, got %q", text)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	const ex = `([0-9]+)\.([0-9]+)\.([0-9]+)`
+	dr := DeferredRegex{Re: ex}
+	out, err := json.Marshal(&dr)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	want, err := json.Marshal(ex)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if string(out) != string(want) {
+		t.Errorf("Expected regex to marshal as a JSON string, got %s", out)
+	}
+
+	var rt DeferredRegex
+	if err := json.Unmarshal(out, &rt); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if rt.Re != ex {
+		t.Errorf("mismatching regexes")
+	}
+}
+
+func TestPatternDoesNotForceCompilation(t *testing.T) {
+	dr := DeferredRegex{Re: `[`}
+	if dr.Pattern() != `[` {
+		t.Errorf("Expected Pattern to return the source pattern")
+	}
+	if dr.re != nil || dr.err != nil {
+		t.Errorf("Expected Pattern not to trigger compilation")
+	}
+}
+
 func TestFlagsMarshaler(t *testing.T) {
 	type FlagMarshaler interface {
 		UnmarshalFlag(value string) error
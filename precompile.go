@@ -0,0 +1,53 @@
+//go:build regex_precompile
+
+package deferredregex
+
+import (
+	"fmt"
+	"sync"
+)
+
+// registry holds every DeferredRegex registered for eager compilation via
+// Register or MustNew. It is only built under the regex_precompile tag.
+var (
+	registryMu sync.Mutex
+	registry   []*DeferredRegex
+)
+
+// Register adds dr to the set of regexes that a subsequent call to CompileAll
+// will compile. Callers should register each instance once; MustNew does this
+// for you.
+func Register(dr *DeferredRegex) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, dr)
+}
+
+// MustNew creates a new DeferredRegex for pattern, registers it with the
+// package-level registry and compiles it immediately, panicking if pattern
+// is invalid so the failure is caught at the call site rather than on first
+// use, mirroring regexp.MustCompile.
+func MustNew(pattern string) *DeferredRegex {
+	dr := &DeferredRegex{Re: pattern}
+	Register(dr)
+	if err := dr.Err(); err != nil {
+		panic(err)
+	}
+	return dr
+}
+
+// CompileAll eagerly compiles every DeferredRegex registered via Register or
+// MustNew, returning the first compile error it encounters along with the
+// offending pattern. Binaries built with -tags=regex_precompile should call
+// this from main so a bad pattern fails fast at startup instead of the first
+// time it's matched against.
+func CompileAll() error {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	for _, dr := range registry {
+		if err := dr.Err(); err != nil {
+			return fmt.Errorf("failed to compile regex %q: %w", dr.Re, err)
+		}
+	}
+	return nil
+}
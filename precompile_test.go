@@ -0,0 +1,35 @@
+//go:build regex_precompile
+
+package deferredregex
+
+import "testing"
+
+func TestCompileAll(t *testing.T) {
+	dr := MustNew(`([0-9]+)\.([0-9]+)\.([0-9]+)`)
+	if err := CompileAll(); err != nil {
+		t.Errorf("Unexpected error %s", err)
+	}
+	if !dr.MatchString("1.2.3") {
+		t.Errorf("Failed to match string")
+	}
+}
+
+func TestMustNewPanicsOnBadPattern(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected MustNew to panic on an invalid pattern")
+		}
+	}()
+	MustNew(`[`)
+}
+
+func TestCompileAllReportsBadPattern(t *testing.T) {
+	registryMu.Lock()
+	registry = nil
+	registryMu.Unlock()
+
+	Register(&DeferredRegex{Re: `[`})
+	if err := CompileAll(); err == nil {
+		t.Errorf("Expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,21 @@
+//go:build regex_yaml
+
+package deferredregex
+
+import "gopkg.in/yaml.v3"
+
+// MarshalYAML implements yaml.v3's Marshaler interface, serialising the
+// DeferredRegex as a YAML scalar string rather than as a struct.
+func (dr *DeferredRegex) MarshalYAML() (interface{}, error) {
+	return dr.marshal(), nil
+}
+
+// UnmarshalYAML implements yaml.v3's Unmarshaler interface.
+// Note that it still defers the parse at this point, unless StrictUnmarshal is set.
+func (dr *DeferredRegex) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	return dr.unmarshal(s)
+}
@@ -0,0 +1,26 @@
+//go:build regex_yaml
+
+package deferredregex
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestYAMLRoundTrip(t *testing.T) {
+	const ex = `([0-9]+)\.([0-9]+)\.([0-9]+)`
+	dr := DeferredRegex{Re: ex}
+	out, err := yaml.Marshal(&dr)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+
+	var rt DeferredRegex
+	if err := yaml.Unmarshal(out, &rt); err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if rt.Re != ex {
+		t.Errorf("mismatching regexes")
+	}
+}